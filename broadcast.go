@@ -0,0 +1,234 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/lukegb/enterthematrix/pkg/runtime"
+)
+
+var (
+	broadcastFlag = flag.Bool("broadcast", false, "run against every matching container at once instead of picking one; stdin is mirrored to all of them and output is tagged with the container name (alias: --all)")
+	allFlag       = flag.Bool("all", false, "alias for --broadcast")
+	serialFlag    = flag.Bool("serial", false, "with --broadcast, run the command against each matching container one at a time instead of concurrently, aggregating exit codes")
+	tmuxFlag      = flag.Bool("tmux", false, "with --broadcast, split the current tmux window into one pane per container instead of tagging output in this terminal")
+)
+
+// wantsBroadcast reports whether --broadcast/--all were passed, in which
+// case we fan out to every candidate container instead of picking one.
+func wantsBroadcast() bool {
+	return *broadcastFlag || *allFlag
+}
+
+// runBroadcast runs cmd (or /bin/bash if cmd is empty) against every
+// container in cs and returns the process exit code to use.
+func runBroadcast(ctx context.Context, rt runtime.Runtime, cs []runtime.Container) int {
+	cmd := []string{"/bin/bash"}
+	if args := flag.Args(); len(args) > 0 {
+		cmd = args
+	}
+
+	switch {
+	case *tmuxFlag:
+		return runBroadcastTmux(rt, cs, cmd)
+	case *serialFlag:
+		return runBroadcastSerial(ctx, rt, cs, cmd)
+	default:
+		return runBroadcastParallel(ctx, rt, cs, cmd)
+	}
+}
+
+// runBroadcastParallel execs cmd in every container concurrently,
+// tagging each container's demuxed output with its name and mirroring
+// stdin to all of them, then waits for every session to finish.
+func runBroadcastParallel(ctx context.Context, rt runtime.Runtime, cs []runtime.Container, cmd []string) int {
+	sessions := make([]runtime.ExecSession, len(cs))
+	for i, c := range cs {
+		session, err := rt.Exec(ctx, c.ID, runtime.ExecConfig{
+			Cmd:          cmd,
+			AttachStdin:  true,
+			AttachStdout: true,
+			AttachStderr: true,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed to start: %v\n", c.Name, err)
+			continue
+		}
+		defer session.Close()
+		sessions[i] = session
+	}
+
+	var stdins []io.Writer
+	for _, s := range sessions {
+		if s != nil {
+			stdins = append(stdins, s)
+		}
+	}
+	if len(stdins) > 0 {
+		go io.Copy(io.MultiWriter(stdins...), os.Stdin)
+	}
+
+	var wg sync.WaitGroup
+	codes := make(map[string]int)
+	var codesMu sync.Mutex
+	for i, c := range cs {
+		session := sessions[i]
+		if session == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(c runtime.Container, session runtime.ExecSession) {
+			defer wg.Done()
+			prefix := fmt.Sprintf("[%s] ", c.Name)
+			stdcopy.StdCopy(newPrefixWriter(os.Stdout, prefix), newPrefixWriter(os.Stderr, prefix), session)
+
+			code, err := session.ExitCode(ctx)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: failed to read exit code: %v\n", c.Name, err)
+				code = 1
+			}
+			codesMu.Lock()
+			codes[c.Name] = code
+			codesMu.Unlock()
+		}(c, session)
+	}
+	wg.Wait()
+
+	return aggregateExitCodes(codes)
+}
+
+// runBroadcastSerial runs cmd against each container in cs one at a
+// time, tagging its output with the container name, and aggregates the
+// exit codes once every container has run.
+func runBroadcastSerial(ctx context.Context, rt runtime.Runtime, cs []runtime.Container, cmd []string) int {
+	codes := make(map[string]int)
+	for _, c := range cs {
+		session, err := rt.Exec(ctx, c.ID, runtime.ExecConfig{
+			Cmd:          cmd,
+			AttachStdout: true,
+			AttachStderr: true,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed to start: %v\n", c.Name, err)
+			codes[c.Name] = 1
+			continue
+		}
+
+		prefix := fmt.Sprintf("[%s] ", c.Name)
+		stdcopy.StdCopy(newPrefixWriter(os.Stdout, prefix), newPrefixWriter(os.Stderr, prefix), session)
+
+		code, err := session.ExitCode(ctx)
+		session.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed to read exit code: %v\n", c.Name, err)
+			code = 1
+		}
+		codes[c.Name] = code
+	}
+
+	return aggregateExitCodes(codes)
+}
+
+// runBroadcastTmux splits the tmux window enterthematrix is running in
+// into one pane per container, each re-invoking this binary pinned to a
+// single container with -name, and returns immediately: once the panes
+// are up, tmux itself is what's driving the session, not us.
+func runBroadcastTmux(rt runtime.Runtime, cs []runtime.Container, cmd []string) int {
+	if os.Getenv("TMUX") == "" {
+		fmt.Fprintln(os.Stderr, "--tmux requires enterthematrix to be run from inside an existing tmux session")
+		return 1
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to find the enterthematrix binary to relaunch: %v\n", err)
+		return 1
+	}
+
+	for _, c := range cs {
+		args := []string{"split-window", self, "-runtime", rt.Name(), "-name", c.Name}
+		args = append(args, cmd...)
+		if err := exec.Command("tmux", args...).Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: tmux split-window failed: %v\n", c.Name, err)
+		}
+	}
+
+	return 0
+}
+
+// aggregateExitCodes prints each container's exit code and returns 0 if
+// every one of them succeeded, 1 otherwise.
+func aggregateExitCodes(codes map[string]int) int {
+	status := 0
+	for name, code := range codes {
+		fmt.Fprintf(os.Stderr, "%s: exit %d\n", name, code)
+		if code != 0 {
+			status = 1
+		}
+	}
+	return status
+}
+
+// prefixWriter prepends prefix to the start of every line written to w,
+// buffering so a write that splits a line never glues the prefix into
+// its middle.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  string
+	pending bool
+}
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: prefix}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		if !p.pending {
+			if _, err := io.WriteString(p.w, p.prefix); err != nil {
+				return n - len(b), err
+			}
+			p.pending = true
+		}
+
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			if _, err := p.w.Write(b[:i+1]); err != nil {
+				return n - len(b), err
+			}
+			p.pending = false
+			b = b[i+1:]
+			continue
+		}
+
+		if _, err := p.w.Write(b); err != nil {
+			return n - len(b), err
+		}
+		b = nil
+	}
+	return n, nil
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{
+			name:   "single line",
+			writes: []string{"hello\n"},
+			want:   "p: hello\n",
+		},
+		{
+			name:   "multiple lines in one write",
+			writes: []string{"hello\nworld\n"},
+			want:   "p: hello\np: world\n",
+		},
+		{
+			name:   "line split across writes",
+			writes: []string{"hel", "lo\n"},
+			want:   "p: hello\n",
+		},
+		{
+			name:   "unterminated trailing write",
+			writes: []string{"hello\nworld"},
+			want:   "p: hello\np: world",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			pw := newPrefixWriter(&buf, "p: ")
+			for _, w := range tt.writes {
+				if _, err := pw.Write([]byte(w)); err != nil {
+					t.Fatalf("Write(%q) returned error: %v", w, err)
+				}
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command matrixexec-stub is the in-container counterpart to
+// pkg/matrixexec: it speaks the server side of the yamux session that
+// matrixexec.NewSession opens from the client, over its own stdin and
+// stdout. matrixexec.Bootstrap uploads and runs it in place of the
+// previous raw-nc relay.
+//
+// The first stream the client opens (channel 0) is the interactive
+// shell and carries no control line: it's wired straight to a spawned
+// shell's stdio. Every later stream the client opens starts with one
+// control-verb line before the raw bytes:
+//
+//	CONNECT host:port   dial out, then relay (serves -L and --copy's peers)
+//	LISTEN addr port    listen, then open a fresh stream per accepted conn
+//	SEND path           write path's contents to the stream (download)
+//	RECV path           read the stream into path (upload)
+//
+// Streams the stub opens on its own initiative - one per connection
+// accepted by a LISTEN'd -R listener - carry no verb, just the "addr
+// port" line named in the LISTEN request that spawned them, so the
+// client can tell which -R forward a given stream belongs to.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/hashicorp/yamux"
+)
+
+// stdio wraps os.Stdin/os.Stdout as the single io.ReadWriteCloser the
+// yamux session frames its streams over.
+type stdio struct{}
+
+func (stdio) Read(p []byte) (int, error)  { return os.Stdin.Read(p) }
+func (stdio) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+func (stdio) Close() error                { return nil }
+
+func main() {
+	session, err := yamux.Server(stdio{}, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "matrixexec-stub: failed to start yamux server: %v\n", err)
+		os.Exit(1)
+	}
+
+	shell, err := session.Accept()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "matrixexec-stub: failed to accept shell channel: %v\n", err)
+		os.Exit(1)
+	}
+	go serveShell(shell)
+
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			return
+		}
+		go serveStream(session, stream)
+	}
+}
+
+// serveShell runs an interactive shell with its stdio wired to stream.
+func serveShell(stream net.Conn) {
+	defer stream.Close()
+
+	path := os.Getenv("SHELL")
+	if path == "" {
+		path = "/bin/sh"
+	}
+	cmd := exec.Command(path)
+	cmd.Stdin = stream
+	cmd.Stdout = stream
+	cmd.Stderr = stream
+	cmd.Run()
+}
+
+// serveStream reads the control verb that opens stream and dispatches
+// to the matching handler.
+func serveStream(session *yamux.Session, stream net.Conn) {
+	defer stream.Close()
+
+	r := bufio.NewReader(stream)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return
+	}
+	verb, rest, _ := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+
+	switch verb {
+	case "CONNECT":
+		serveConnect(stream, r, rest)
+	case "LISTEN":
+		serveListen(session, rest)
+	case "SEND":
+		serveSend(stream, rest)
+	case "RECV":
+		serveRecv(stream, r, rest)
+	}
+}
+
+// serveConnect dials target and relays it against stream, carrying over
+// any bytes already buffered past the control line.
+func serveConnect(stream net.Conn, buffered *bufio.Reader, target string) {
+	conn, err := net.Dial("tcp", target)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	relay(conn, stream, buffered)
+}
+
+// serveListen implements -R: listen on addr:port and, for each accepted
+// connection, open a fresh mux stream back to the client, tagged with
+// the same "addr port" the LISTEN request named, so ServeRemoteForward
+// can tell which -R forward the stream belongs to before relaying.
+func serveListen(session *yamux.Session, addrPort string) {
+	parts := strings.SplitN(addrPort, " ", 2)
+	if len(parts) != 2 {
+		return
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(parts[0], parts[1]))
+	if err != nil {
+		return
+	}
+	defer ln.Close()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			stream, err := session.Open()
+			if err != nil {
+				return
+			}
+			defer stream.Close()
+			if _, err := fmt.Fprintf(stream, "%s\n", addrPort); err != nil {
+				return
+			}
+			relay(conn, stream, nil)
+		}()
+	}
+}
+
+// serveSend writes path's contents to stream, for a container->local
+// --copy.
+func serveSend(stream net.Conn, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	io.Copy(stream, f)
+}
+
+// serveRecv reads stream into path, for a local->container --copy.
+func serveRecv(stream net.Conn, buffered io.Reader, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	io.Copy(f, buffered)
+}
+
+// relay copies bytes in both directions between a and b until either
+// side closes, first draining any bytes already buffered for a past a's
+// control line.
+func relay(a, b net.Conn, buffered io.Reader) {
+	done := make(chan struct{}, 2)
+	go func() {
+		if buffered != nil {
+			io.Copy(a, buffered)
+		}
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() { io.Copy(b, a); done <- struct{}{} }()
+	<-done
+}
@@ -18,152 +18,302 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
-	"regexp"
+	"strings"
 	"syscall"
 	"time"
 
 	"golang.org/x/crypto/ssh/terminal"
 
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/lukegb/enterthematrix/pkg/asciicast"
+	"github.com/lukegb/enterthematrix/pkg/matrixexec"
+	"github.com/lukegb/enterthematrix/pkg/picker"
+	"github.com/lukegb/enterthematrix/pkg/runtime"
 )
 
 var (
-	validName = regexp.MustCompile(`^.*_[a-f0-9]{8}$`)
+	runtimeName = flag.String("runtime", "", "container runtime to use: docker, podman, or containerd (default: auto-detect from DOCKER_HOST/CONTAINER_HOST/CONTAINERD_ADDRESS)")
+	noTTY       = flag.Bool("no-tty", false, "run without a TTY, demuxing stdout/stderr with stdcopy; for one-shot commands, e.g. enterthematrix --no-tty -- ls /")
+
+	localForwards  stringSliceFlag
+	remoteForwards stringSliceFlag
+	copySpec       = flag.String("copy", "", `copy a file to/from the container: src:dst, with the container-side path prefixed "container:"`)
 )
 
-func selectContainer(cs []types.Container) types.Container {
+func init() {
+	flag.Var(&localForwards, "L", "forward a local port into the container: addr:port:target (repeatable)")
+	flag.Var(&remoteForwards, "R", "forward a container port back out to the client: addr:port:target (repeatable)")
+}
+
+// stringSliceFlag collects repeated occurrences of a flag into a slice,
+// e.g. -L addr:port:target -L addr:port:target.
+type stringSliceFlag []string
+
+func (f *stringSliceFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringSliceFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// selectContainer picks a single container out of cs: automatically if
+// there's only one, via the interactive fuzzy picker if stdout is a
+// terminal and no --format was requested, or by printing a
+// machine-readable listing and asking the caller to narrow down with
+// --name/--filter otherwise.
+func selectContainer(cs []runtime.Container) (runtime.Container, error) {
 	if len(cs) == 1 {
 		c := cs[0]
-		fmt.Printf("Automatically selected %s, as it's the only running server.\n", c.Names[0])
-		return c
-	}
-
-	fmt.Printf("There are %d running servers:\n", len(cs))
-	for n, c := range cs {
-		fmt.Printf(" [%d] %s\n", n, c.Names[0])
+		fmt.Printf("Automatically selected %s, as it's the only running server.\n", c.Name)
+		return c, nil
 	}
-	fmt.Printf("\n")
 
-	fmt.Printf("Choice: ")
-	var i int
-	for {
-		_, err := fmt.Scanf("%d", &i)
-		if err != nil {
-			fmt.Printf("Hmm, that doesn't look like a number: %v", err)
-			continue
+	explicitFormat := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "format" {
+			explicitFormat = true
 		}
+	})
 
-		if i < 0 || i >= len(cs) {
-			fmt.Printf("Please enter a number between 0 and %d inclusive.", len(cs)-1)
-			continue
+	if explicitFormat || !terminal.IsTerminal(int(os.Stdout.Fd())) {
+		if err := printListing(os.Stdout, cs, *formatFlag); err != nil {
+			return runtime.Container{}, err
 		}
+		return runtime.Container{}, fmt.Errorf("%d containers match; narrow down with --name or --filter", len(cs))
+	}
 
-		break
+	c, ok, err := picker.Pick(cs)
+	if err != nil {
+		return runtime.Container{}, fmt.Errorf("failed to run picker: %v", err)
 	}
+	if !ok {
+		return runtime.Container{}, fmt.Errorf("no container selected")
+	}
+	return c, nil
+}
 
-	return cs[i]
+// wantsMux reports whether any of the forwarding/copy flags were passed,
+// in which case we need a mux-carrying exec session instead of attaching
+// directly to /bin/bash.
+func wantsMux() bool {
+	return len(localForwards) > 0 || len(remoteForwards) > 0 || *copySpec != ""
+}
+
+// startMuxSession uploads and runs matrixexec-stub against containerID
+// and wraps the resulting exec session in a matrixexec.Session.
+func startMuxSession(ctx context.Context, rt runtime.Runtime, containerID string) (*matrixexec.Session, error) {
+	return matrixexec.Bootstrap(ctx, rt, containerID)
 }
 
 func main() {
-	cli, err := client.NewEnvClient()
+	os.Exit(run())
+}
+
+// run is main's body, returning the process exit code instead of calling
+// os.Exit directly, so that the defers set up along the way (restoring
+// the terminal, closing shellConn/rec/the mux session) actually run
+// before the process exits.
+func run() int {
+	flag.Parse()
+
+	rt, err := runtime.Detect(*runtimeName)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to connect to Docker API: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
 	}
 
 	ctx := context.Background()
 
-	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{})
+	containers, err := rt.ListContainers(ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to list containers: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 
-	var validContainers []types.Container
-	for _, container := range containers {
-		if len(container.Names) != 1 {
-			// Get out of here with your multiple names
-			continue
-		}
-
-		name := container.Names[0]
-		if !validName.MatchString(name) {
-			// Nope, this doesn't look like a container we want
-			continue
-		}
-
-		validContainers = append(validContainers, container)
+	validContainers, err := candidateContainers(containers)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
 	}
 
 	if len(validContainers) == 0 {
 		fmt.Fprintf(os.Stderr, "Whoops - there are no running servers at the moment. Start one, and come back later.\n")
-		os.Exit(1)
+		return 1
 	}
 
-	selectedContainer := selectContainer(validContainers)
-	// Create exec description
-	createResp, err := cli.ContainerExecCreate(ctx, selectedContainer.ID, types.ExecConfig{
-		Tty:          true,
-		AttachStdin:  true,
-		AttachStderr: true,
-		AttachStdout: true,
-		Cmd:          []string{"/bin/bash"},
-	})
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create an exec environment: %v\n", err)
-		os.Exit(1)
+	if wantsBroadcast() {
+		return runBroadcast(ctx, rt, validContainers)
 	}
 
-	execID := createResp.ID
-
-	// Attach to the exec environment
-	hijackResp, err := cli.ContainerExecAttach(ctx, execID, types.ExecConfig{
-		Tty:          true,
-		AttachStdin:  true,
-		AttachStderr: true,
-		AttachStdout: true,
-	})
+	selectedContainer, err := selectContainer(validContainers)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to attach to exec environment: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
 	}
-	defer hijackResp.Close()
-	defer hijackResp.CloseWrite()
 
-	winchChan := make(chan os.Signal)
-	signal.Notify(winchChan, syscall.SIGWINCH)
-	go func() {
-		for range winchChan {
-			width, height, err := terminal.GetSize(syscall.Stdin)
+	// shellConn carries the interactive session; resize, if non-nil, is
+	// wired up to SIGWINCH below. Plain attaches resize the exec's TTY
+	// directly, while mux sessions don't currently support resizing the
+	// in-container shell. execSession is additionally non-nil for plain
+	// attaches, so we can poll its real exit code once the session ends.
+	var shellConn io.ReadWriteCloser
+	var resize func(width, height uint)
+	var execSession runtime.ExecSession
+
+	if wantsMux() {
+		session, err := startMuxSession(ctx, rt, selectedContainer.ID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		defer session.Close()
+
+		for _, spec := range localForwards {
+			fw, err := matrixexec.ParseLocalForward(spec)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return 1
+			}
+			if err := session.ServeLocalForward(fw); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return 1
+			}
+		}
+		for _, spec := range remoteForwards {
+			fw, err := matrixexec.ParseRemoteForward(spec)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return 1
+			}
+			if err := session.ServeRemoteForward(fw); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return 1
+			}
+		}
+		if *copySpec != "" {
+			spec, err := matrixexec.ParseCopySpec(*copySpec)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%v\n", err)
-				continue
+				return 1
 			}
-			if err := cli.ContainerExecResize(ctx, execID, types.ResizeOptions{
-				Height: uint(height),
-				Width:  uint(width),
-			}); err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to resize container TTY: %v\n", err)
+			if err := session.Copy(spec); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return 1
 			}
+			return 0
 		}
-	}()
-	defer close(winchChan)
-	time.Sleep(100 * time.Millisecond)
-	winchChan <- syscall.SIGWINCH
 
-	// switch to raw
-	terminalState, err := terminal.MakeRaw(syscall.Stdin)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to make terminal raw: %v\n", err)
-		os.Exit(1)
+		shellConn = session.Shell()
+	} else {
+		cmd := []string{"/bin/bash"}
+		if args := flag.Args(); len(args) > 0 {
+			cmd = args
+		}
+
+		reconnecting, err := runtime.NewReconnecting(ctx, rt, selectedContainer.ID, runtime.ExecConfig{
+			Tty:          !*noTTY,
+			AttachStdin:  true,
+			AttachStdout: true,
+			AttachStderr: true,
+			Cmd:          cmd,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to start exec session: %v\n", err)
+			return 1
+		}
+
+		execSession = reconnecting
+		shellConn = reconnecting
+		if !*noTTY {
+			resize = func(width, height uint) {
+				if err := reconnecting.Resize(ctx, runtime.ResizeConfig{Width: width, Height: height}); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to resize container TTY: %v\n", err)
+				}
+			}
+		}
 	}
-	defer terminal.Restore(syscall.Stdin, terminalState)
+	defer shellConn.Close()
 
-	go io.Copy(hijackResp.Conn, os.Stdin)
-	io.Copy(os.Stdout, hijackResp.Conn)
+	var rec *recording
+	if wantsRecording() {
+		width, height, err := terminal.GetSize(syscall.Stdin)
+		if err != nil {
+			width, height = 80, 24
+		}
+		rec, err = startRecording(width, height)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		defer rec.Close()
+	}
+
+	if resize != nil {
+		doResize := resize
+		resize = func(width, height uint) {
+			doResize(width, height)
+			rec.recordResize(width, height)
+		}
+
+		winchChan := make(chan os.Signal, 1)
+		signal.Notify(winchChan, syscall.SIGWINCH)
+		go func() {
+			for range winchChan {
+				width, height, err := terminal.GetSize(syscall.Stdin)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%v\n", err)
+					continue
+				}
+				resize(uint(width), uint(height))
+			}
+		}()
+		defer close(winchChan)
+		time.Sleep(100 * time.Millisecond)
+		winchChan <- syscall.SIGWINCH
+	}
+
+	var outWriter io.Writer = os.Stdout
+	var inWriter io.Writer = shellConn
+	if rec != nil {
+		outWriter = asciicast.NewTeeWriter(os.Stdout, rec.sink, asciicast.Output)
+		inWriter = asciicast.NewTeeWriter(shellConn, rec.sink, asciicast.Input)
+	}
+
+	if *noTTY {
+		// No TTY means Docker (and friends) frame stdout/stderr as
+		// length-prefixed chunks rather than one raw stream; demux them
+		// instead of copying byte-for-byte.
+		go io.Copy(inWriter, os.Stdin)
+		if _, err := stdcopy.StdCopy(outWriter, os.Stderr, shellConn); err != nil && err != io.EOF {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	} else {
+		terminalState, err := terminal.MakeRaw(syscall.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to make terminal raw: %v\n", err)
+			return 1
+		}
+		defer terminal.Restore(syscall.Stdin, terminalState)
+
+		go io.Copy(inWriter, os.Stdin)
+		io.Copy(outWriter, shellConn)
+	}
+
+	if execSession != nil {
+		code, err := execSession.ExitCode(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read exit code: %v\n", err)
+			return 1
+		}
+		return code
+	}
+	return 0
 }
@@ -0,0 +1,62 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lukegb/enterthematrix/pkg/runtime"
+)
+
+var formatFlag = flag.String("format", "table", "listing format when the picker falls back to non-interactive output: table or json")
+
+// printListing writes containers to w in format ("table" or "json"),
+// for scripting against a non-TTY stdout or an explicit --format.
+func printListing(w io.Writer, containers []runtime.Container, format string) error {
+	switch format {
+	case "json":
+		return json.NewEncoder(w).Encode(containers)
+	case "table", "":
+		tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tIMAGE\tUPTIME\tLABELS")
+		for _, c := range containers {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Name, c.Image, time.Since(c.Created).Round(time.Second), formatLabels(c.Labels))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown --format %q, want table or json", format)
+	}
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return "-"
+	}
+	s := ""
+	for k, v := range labels {
+		if s != "" {
+			s += ","
+		}
+		s += k + "=" + v
+	}
+	return s
+}
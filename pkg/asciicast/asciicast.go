@@ -0,0 +1,102 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package asciicast records a terminal session in the asciinema
+// asciicast v2 format: a JSON header line followed by one JSON array
+// per frame, and optionally fans the same frames out over a WebSocket
+// for live viewing.
+package asciicast
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// EventType is the single-character frame type asciicast v2 uses to
+// distinguish output, input, and terminal-resize frames.
+type EventType string
+
+const (
+	Output EventType = "o"
+	Input  EventType = "i"
+	Resize EventType = "r"
+)
+
+// Header is the first line of an asciicast v2 file.
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// EventSink receives recorded frames; Writer (a .cast file) and WSSink
+// (a live WebSocket viewer) both implement it, and MultiSink fans out
+// to several at once.
+type EventSink interface {
+	WriteEvent(t time.Time, typ EventType, data string) error
+}
+
+// Writer writes an asciicast v2 stream: a header line, then one frame
+// per WriteEvent call, timestamped relative to when it was created.
+type Writer struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	start time.Time
+}
+
+// NewWriter writes the asciicast v2 header to w and returns a Writer
+// ready to record frames starting now.
+func NewWriter(w io.Writer, width, height int, env map[string]string) (*Writer, error) {
+	start := time.Now()
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(Header{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: start.Unix(),
+		Env:       env,
+	}); err != nil {
+		return nil, err
+	}
+	return &Writer{enc: enc, start: start}, nil
+}
+
+// WriteEvent appends a single [elapsed, type, data] frame.
+func (cw *Writer) WriteEvent(t time.Time, typ EventType, data string) error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	elapsed := t.Sub(cw.start).Seconds()
+	return cw.enc.Encode([]interface{}{elapsed, string(typ), data})
+}
+
+// MultiSink fans a single WriteEvent call out to every sink, returning
+// the first error encountered (after still attempting every sink).
+type MultiSink []EventSink
+
+func (m MultiSink) WriteEvent(t time.Time, typ EventType, data string) error {
+	var firstErr error
+	for _, sink := range m {
+		if err := sink.WriteEvent(t, typ, data); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
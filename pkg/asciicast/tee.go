@@ -0,0 +1,76 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package asciicast
+
+import (
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// TeeWriter wraps an io.Writer, recording every chunk written through it
+// as an event on sink before passing it on. Terminal raw mode means
+// Docker's demuxed stream can split a multi-byte UTF-8 character across
+// two Write calls; TeeWriter holds back any trailing partial rune until
+// the bytes that complete it arrive, so every recorded frame is valid
+// UTF-8.
+type TeeWriter struct {
+	w       io.Writer
+	sink    EventSink
+	typ     EventType
+	pending []byte
+}
+
+// NewTeeWriter returns a TeeWriter that forwards writes to w and records
+// them on sink as frames of typ.
+func NewTeeWriter(w io.Writer, sink EventSink, typ EventType) *TeeWriter {
+	return &TeeWriter{w: w, sink: sink, typ: typ}
+}
+
+func (t *TeeWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	if n > 0 {
+		t.record(p[:n])
+	}
+	return n, err
+}
+
+func (t *TeeWriter) record(p []byte) {
+	buf := append(t.pending, p...)
+	safe := utf8SafePrefix(buf)
+	if len(safe) > 0 {
+		// Recording is best-effort: a failed sink (e.g. a dropped
+		// --stream websocket) shouldn't interrupt the session.
+		t.sink.WriteEvent(time.Now(), t.typ, string(safe))
+	}
+	t.pending = append(t.pending[:0], buf[len(safe):]...)
+}
+
+// utf8SafePrefix returns the longest prefix of buf that doesn't end
+// mid-rune, so a split multi-byte character is carried over to the next
+// Write instead of being recorded as mangled bytes.
+func utf8SafePrefix(buf []byte) []byte {
+	if len(buf) == 0 || utf8.Valid(buf) {
+		return buf
+	}
+	for i := len(buf) - 1; i >= 0 && len(buf)-i < utf8.UTFMax; i-- {
+		if utf8.RuneStart(buf[i]) {
+			return buf[:i]
+		}
+	}
+	return buf
+}
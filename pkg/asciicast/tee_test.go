@@ -0,0 +1,47 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package asciicast
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUtf8SafePrefix(t *testing.T) {
+	euro := []byte("€") // 3 bytes: 0xE2 0x82 0xAC
+
+	tests := []struct {
+		name string
+		buf  []byte
+		want []byte
+	}{
+		{name: "empty", buf: nil, want: nil},
+		{name: "all ascii", buf: []byte("hello"), want: []byte("hello")},
+		{name: "complete multibyte rune", buf: euro, want: euro},
+		{name: "split after first byte", buf: euro[:1], want: nil},
+		{name: "split after second byte", buf: euro[:2], want: nil},
+		{name: "trailing split rune", buf: append([]byte("hi"), euro[:2]...), want: []byte("hi")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := utf8SafePrefix(tt.buf)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("utf8SafePrefix(%v) = %v, want %v", tt.buf, got, tt.want)
+			}
+		})
+	}
+}
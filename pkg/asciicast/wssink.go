@@ -0,0 +1,79 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package asciicast
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WSSink pushes the same frames a Writer would record to a WebSocket,
+// for --stream live sharing. It sends the header as the first text
+// message, then one frame per message after that.
+type WSSink struct {
+	mu    sync.Mutex
+	conn  *websocket.Conn
+	start time.Time
+}
+
+// DialWS connects to url and sends the asciicast header as the first
+// message, mirroring what Writer writes to the record file.
+func DialWS(url string, width, height int, env map[string]string) (*WSSink, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("asciicast: failed to dial --stream %s: %v", url, err)
+	}
+
+	start := time.Now()
+	header := Header{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: start.Unix(),
+		Env:       env,
+	}
+	if err := conn.WriteJSON(header); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("asciicast: failed to send header to %s: %v", url, err)
+	}
+
+	return &WSSink{conn: conn, start: start}, nil
+}
+
+// WriteEvent sends a single [elapsed, type, data] frame as a text
+// message, timestamped the same way Writer.WriteEvent is. gorilla's
+// websocket.Conn forbids concurrent writers, so this guards conn with a
+// mutex the same way Writer guards its encoder.
+func (s *WSSink) WriteEvent(t time.Time, typ EventType, data string) error {
+	b, err := json.Marshal([]interface{}{t.Sub(s.start).Seconds(), string(typ), data})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.WriteMessage(websocket.TextMessage, b)
+}
+
+// Close tears down the WebSocket connection.
+func (s *WSSink) Close() error {
+	return s.conn.Close()
+}
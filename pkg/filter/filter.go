@@ -0,0 +1,104 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package filter implements the --filter predicates enterthematrix
+// applies to a container listing before handing it to the picker.
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lukegb/enterthematrix/pkg/runtime"
+)
+
+// Filter matches or rejects a container. Spec returns the --filter
+// argument that would reproduce it, for error messages.
+type Filter interface {
+	Match(c runtime.Container) bool
+	Spec() string
+}
+
+type labelFilter struct {
+	spec, key, value string
+}
+
+func (f labelFilter) Match(c runtime.Container) bool {
+	return c.Labels[f.key] == f.value
+}
+func (f labelFilter) Spec() string { return f.spec }
+
+type nameRegexpFilter struct {
+	spec string
+	re   *regexp.Regexp
+}
+
+func (f nameRegexpFilter) Match(c runtime.Container) bool {
+	return f.re.MatchString(c.Name)
+}
+func (f nameRegexpFilter) Spec() string { return f.spec }
+
+// Parse turns a --filter argument into a Filter. Supported forms:
+//
+//	label=key=value   container must carry the label key=value
+//	name=~regex       container name must match regex
+func Parse(spec string) (Filter, error) {
+	kind, rest, ok := strings.Cut(spec, "=")
+	if !ok {
+		return nil, fmt.Errorf("filter: malformed --filter %q, want label=key=value or name=~regex", spec)
+	}
+
+	switch kind {
+	case "label":
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			return nil, fmt.Errorf("filter: malformed --filter %q, want label=key=value", spec)
+		}
+		return labelFilter{spec: spec, key: key, value: value}, nil
+	case "name":
+		pattern := strings.TrimPrefix(rest, "~")
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("filter: invalid --filter name regex %q: %v", pattern, err)
+		}
+		return nameRegexpFilter{spec: spec, re: re}, nil
+	default:
+		return nil, fmt.Errorf("filter: unknown --filter kind %q, want label or name", kind)
+	}
+}
+
+// Apply keeps only the containers every filter matches.
+func Apply(cs []runtime.Container, filters []Filter) []runtime.Container {
+	if len(filters) == 0 {
+		return cs
+	}
+
+	var out []runtime.Container
+	for _, c := range cs {
+		matched := true
+		for _, f := range filters {
+			if !f.Match(c) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"testing"
+
+	"github.com/lukegb/enterthematrix/pkg/runtime"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec    string
+		c       runtime.Container
+		want    bool
+		wantErr bool
+	}{
+		{
+			spec: "label=env=prod",
+			c:    runtime.Container{Labels: map[string]string{"env": "prod"}},
+			want: true,
+		},
+		{
+			spec: "label=env=prod",
+			c:    runtime.Container{Labels: map[string]string{"env": "dev"}},
+			want: false,
+		},
+		{
+			spec: "name=~^web-",
+			c:    runtime.Container{Name: "web-1"},
+			want: true,
+		},
+		{
+			spec: "name=~^web-",
+			c:    runtime.Container{Name: "db-1"},
+			want: false,
+		},
+		{spec: "name=~(", wantErr: true},
+		{spec: "nocolonorequals", wantErr: true},
+		{spec: "bogus=whatever", wantErr: true},
+	}
+	for _, tt := range tests {
+		f, err := Parse(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%q) = %+v, want error", tt.spec, f)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q) unexpected error: %v", tt.spec, err)
+			continue
+		}
+		if got := f.Match(tt.c); got != tt.want {
+			t.Errorf("Parse(%q).Match(%+v) = %v, want %v", tt.spec, tt.c, got, tt.want)
+		}
+		if got := f.Spec(); got != tt.spec {
+			t.Errorf("Parse(%q).Spec() = %q, want %q", tt.spec, got, tt.spec)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package matrixexec
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+
+	execruntime "github.com/lukegb/enterthematrix/pkg/runtime"
+)
+
+// stubBinary is the statically-linked matrixexec-stub (cmd/matrixexec-stub),
+// built by `make stub` for linux/amd64. Earlier revisions of this package
+// ran raw nc/socat as the control exec session instead, but neither
+// speaks yamux, so the client's first session.Open() just hung; the
+// uploaded stub is the actual yamux server peer.
+//
+//go:embed stub/bin/matrixexec-stub-linux-amd64
+var stubBinary []byte
+
+// stubPath is where Bootstrap uploads the stub binary inside the
+// container before exec'ing it.
+const stubPath = "/tmp/.matrixexec-stub"
+
+// Bootstrap uploads the embedded matrixexec-stub binary into containerID
+// and execs it, wrapping the resulting exec session in a Session.
+func Bootstrap(ctx context.Context, rt execruntime.Runtime, containerID string) (*Session, error) {
+	if len(stubBinary) == 0 {
+		return nil, fmt.Errorf("matrixexec: embedded stub binary is empty; run `make stub` before building")
+	}
+	if err := uploadStub(ctx, rt, containerID); err != nil {
+		return nil, err
+	}
+
+	execSession, err := rt.Exec(ctx, containerID, execruntime.ExecConfig{
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          []string{stubPath},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("matrixexec: failed to start stub exec session: %v", err)
+	}
+	return NewSession(execSession)
+}
+
+// uploadStub writes stubBinary to stubPath inside containerID by piping
+// it through a one-shot `cat` exec session, then makes it executable.
+func uploadStub(ctx context.Context, rt execruntime.Runtime, containerID string) error {
+	session, err := rt.Exec(ctx, containerID, execruntime.ExecConfig{
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          []string{"sh", "-c", fmt.Sprintf("cat > %s && chmod +x %s", stubPath, stubPath)},
+	})
+	if err != nil {
+		return fmt.Errorf("matrixexec: failed to start stub upload session: %v", err)
+	}
+
+	if _, err := session.Write(stubBinary); err != nil {
+		session.Close()
+		return fmt.Errorf("matrixexec: failed to upload stub binary: %v", err)
+	}
+	if err := session.Close(); err != nil {
+		return fmt.Errorf("matrixexec: failed to close stub upload session: %v", err)
+	}
+	if code, err := session.ExitCode(ctx); err == nil && code != 0 {
+		return fmt.Errorf("matrixexec: stub upload exited %d", code)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package matrixexec
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// containerPrefix marks which half of a CopySpec refers to a path
+// inside the container, matching scp's "host:path" convention.
+const containerPrefix = "container:"
+
+// CopySpec describes a `--copy src:dst` request, mirroring scp's
+// [[user@]host:]path syntax except that the host component, when
+// present, is always the selected container.
+type CopySpec struct {
+	Src string
+	Dst string
+}
+
+// ParseCopySpec parses the argument to --copy. Exactly one of Src/Dst
+// must be prefixed with "container:"; the other is a local path.
+//
+// Splitting on the first colon would break the download direction,
+// since the container side is itself "container:/some/path" - its own
+// colon is the first one in the spec. Instead, split on whichever side
+// actually carries the containerPrefix marker.
+func ParseCopySpec(spec string) (CopySpec, error) {
+	if n := strings.Count(spec, containerPrefix); n != 1 {
+		return CopySpec{}, fmt.Errorf("matrixexec: ambiguous --copy spec %q, found %d occurrences of %q", spec, n, containerPrefix)
+	}
+
+	var src, dst string
+	switch {
+	case strings.HasPrefix(spec, containerPrefix):
+		rest := strings.TrimPrefix(spec, containerPrefix)
+		idx := strings.LastIndex(rest, ":")
+		if idx < 0 {
+			return CopySpec{}, fmt.Errorf("matrixexec: malformed --copy spec %q, want src:dst", spec)
+		}
+		src, dst = containerPrefix+rest[:idx], rest[idx+1:]
+	case strings.Contains(spec, ":"+containerPrefix):
+		idx := strings.Index(spec, ":"+containerPrefix)
+		src, dst = spec[:idx], spec[idx+1:]
+	default:
+		return CopySpec{}, fmt.Errorf("matrixexec: malformed --copy spec %q, want src:dst", spec)
+	}
+	if isContainerPath(src) == isContainerPath(dst) {
+		return CopySpec{}, fmt.Errorf("matrixexec: --copy needs exactly one side prefixed %q, got %q -> %q", containerPrefix, src, dst)
+	}
+	return CopySpec{Src: src, Dst: dst}, nil
+}
+
+func isContainerPath(p string) bool {
+	return strings.HasPrefix(p, containerPrefix)
+}
+
+func stripContainerPrefix(p string) string {
+	return strings.TrimPrefix(p, containerPrefix)
+}
+
+// Copy pushes or pulls a file over a dedicated mux stream, depending on
+// which half of spec names the container side.
+func (s *Session) Copy(spec CopySpec) error {
+	stream, err := s.mux.Open()
+	if err != nil {
+		return fmt.Errorf("matrixexec: failed to open copy stream: %v", err)
+	}
+	defer stream.Close()
+
+	switch {
+	case isContainerPath(spec.Src) && !isContainerPath(spec.Dst):
+		if _, err := fmt.Fprintf(stream, "SEND %s\n", stripContainerPrefix(spec.Src)); err != nil {
+			return fmt.Errorf("matrixexec: failed to request SEND: %v", err)
+		}
+		f, err := os.Create(spec.Dst)
+		if err != nil {
+			return fmt.Errorf("matrixexec: failed to create %s: %v", spec.Dst, err)
+		}
+		defer f.Close()
+		_, err = io.Copy(f, stream)
+		return err
+	case isContainerPath(spec.Dst) && !isContainerPath(spec.Src):
+		f, err := os.Open(spec.Src)
+		if err != nil {
+			return fmt.Errorf("matrixexec: failed to open %s: %v", spec.Src, err)
+		}
+		defer f.Close()
+		if _, err := fmt.Fprintf(stream, "RECV %s\n", stripContainerPrefix(spec.Dst)); err != nil {
+			return fmt.Errorf("matrixexec: failed to request RECV: %v", err)
+		}
+		_, err = io.Copy(stream, f)
+		return err
+	default:
+		return fmt.Errorf("matrixexec: --copy needs exactly one side prefixed %q, got %q -> %q", containerPrefix, spec.Src, spec.Dst)
+	}
+}
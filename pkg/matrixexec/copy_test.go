@@ -0,0 +1,59 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package matrixexec
+
+import "testing"
+
+func TestParseCopySpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    CopySpec
+		wantErr bool
+	}{
+		{
+			spec: "container:/etc/hostname:./out",
+			want: CopySpec{Src: "container:/etc/hostname", Dst: "./out"},
+		},
+		{
+			spec: "./out:container:/etc/hostname",
+			want: CopySpec{Src: "./out", Dst: "container:/etc/hostname"},
+		},
+		{
+			spec: "container:/a/b/c:/local/d",
+			want: CopySpec{Src: "container:/a/b/c", Dst: "/local/d"},
+		},
+		{spec: "noColonHere", wantErr: true},
+		{spec: "local:alsolocal", wantErr: true},
+		{spec: "container:/a:container:/b", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCopySpec(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseCopySpec(%q) = %+v, want error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCopySpec(%q) unexpected error: %v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseCopySpec(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
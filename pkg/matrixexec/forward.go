@@ -0,0 +1,181 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package matrixexec
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// LocalForward describes a `-L addr:port:target` request: listen on
+// addr:port locally and forward each accepted connection to target
+// (host:port, as resolved from inside the container).
+type LocalForward struct {
+	ListenAddr string
+	ListenPort string
+	Target     string
+}
+
+// RemoteForward describes a `-R addr:port:target` request: the inverse
+// of LocalForward, listening inside the container and forwarding back to
+// target as resolved from the client's side.
+type RemoteForward struct {
+	ListenAddr string
+	ListenPort string
+	Target     string
+}
+
+// ParseLocalForward parses the argument to -L.
+func ParseLocalForward(spec string) (LocalForward, error) {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) != 3 {
+		return LocalForward{}, fmt.Errorf("matrixexec: malformed -L spec %q, want addr:port:target", spec)
+	}
+	return LocalForward{ListenAddr: parts[0], ListenPort: parts[1], Target: parts[2]}, nil
+}
+
+// ParseRemoteForward parses the argument to -R.
+func ParseRemoteForward(spec string) (RemoteForward, error) {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) != 3 {
+		return RemoteForward{}, fmt.Errorf("matrixexec: malformed -R spec %q, want addr:port:target", spec)
+	}
+	return RemoteForward{ListenAddr: parts[0], ListenPort: parts[1], Target: parts[2]}, nil
+}
+
+// ServeLocalForward listens on fw.ListenAddr:fw.ListenPort and, for each
+// accepted connection, opens a new mux stream asking the in-container
+// stub to dial fw.Target, then relays bytes in both directions.
+func (s *Session) ServeLocalForward(fw LocalForward) error {
+	ln, err := net.Listen("tcp", net.JoinHostPort(fw.ListenAddr, fw.ListenPort))
+	if err != nil {
+		return fmt.Errorf("matrixexec: failed to listen for -L %s: %v", fw.Target, err)
+	}
+	go func() {
+		defer ln.Close()
+		for {
+			local, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.dialAndRelay(local, fw.Target)
+		}
+	}()
+	return nil
+}
+
+// ServeRemoteForward asks the in-container stub to listen on
+// fw.ListenAddr:fw.ListenPort. The stub opens a fresh mux stream for
+// each connection it accepts there, tagged with the same "addr port" it
+// was told to LISTEN on (see serveListen in cmd/matrixexec-stub) before
+// relaying; every active -R shares the one underlying yamux Accept
+// queue, so a single per-Session goroutine reads that tag and routes
+// the stream to whichever -R registered it, rather than each -R's own
+// Accept loop racing the others for connections.
+func (s *Session) ServeRemoteForward(fw RemoteForward) error {
+	key := fw.ListenAddr + " " + fw.ListenPort
+
+	s.remoteForwardsMu.Lock()
+	if s.remoteForwards == nil {
+		s.remoteForwards = make(map[string]string)
+	}
+	if _, dup := s.remoteForwards[key]; dup {
+		s.remoteForwardsMu.Unlock()
+		return fmt.Errorf("matrixexec: already forwarding %s:%s", fw.ListenAddr, fw.ListenPort)
+	}
+	s.remoteForwards[key] = fw.Target
+	s.remoteForwardsMu.Unlock()
+
+	s.remoteAcceptOnce.Do(func() { go s.acceptRemoteForwards() })
+
+	ctrl, err := s.mux.Open()
+	if err != nil {
+		return fmt.Errorf("matrixexec: failed to open control stream for -R %s: %v", fw.Target, err)
+	}
+	if _, err := fmt.Fprintf(ctrl, "LISTEN %s\n", key); err != nil {
+		ctrl.Close()
+		return fmt.Errorf("matrixexec: failed to request remote listener: %v", err)
+	}
+	return nil
+}
+
+// acceptRemoteForwards accepts every mux stream the stub opens on its
+// own initiative - one per connection accepted by a LISTEN'd -R
+// listener - and routes each to the -R forward it's tagged for.
+func (s *Session) acceptRemoteForwards() {
+	for {
+		stream, err := s.mux.Accept()
+		if err != nil {
+			return
+		}
+		go s.dispatchRemoteForward(stream)
+	}
+}
+
+// dispatchRemoteForward reads the "addr port" tag serveListen writes
+// ahead of an accepted connection's bytes and relays it against
+// whichever -R forward's target registered that tag.
+func (s *Session) dispatchRemoteForward(stream net.Conn) {
+	r := bufio.NewReader(stream)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		stream.Close()
+		return
+	}
+	key := strings.TrimSuffix(line, "\n")
+
+	s.remoteForwardsMu.Lock()
+	target, ok := s.remoteForwards[key]
+	s.remoteForwardsMu.Unlock()
+	if !ok {
+		stream.Close()
+		return
+	}
+	dialLocalAndRelay(stream, r, target)
+}
+
+// dialLocalAndRelay dials target on the client's own network and relays
+// it against stream, the already-accepted and tag-consumed mux stream
+// for one -R connection; buffered carries any bytes the tag read
+// already pulled past the tag line.
+func dialLocalAndRelay(stream net.Conn, buffered io.Reader, target string) {
+	local, err := net.Dial("tcp", target)
+	if err != nil {
+		stream.Close()
+		return
+	}
+	relay(local, stream, buffered)
+}
+
+// dialAndRelay opens a fresh mux stream, asks the stub to CONNECT to
+// target, and relays local against it until either side closes.
+func (s *Session) dialAndRelay(local net.Conn, target string) {
+	stream, err := s.mux.Open()
+	if err != nil {
+		local.Close()
+		return
+	}
+	if _, err := fmt.Fprintf(stream, "CONNECT %s\n", target); err != nil {
+		local.Close()
+		stream.Close()
+		return
+	}
+	relay(local, stream, nil)
+}
@@ -0,0 +1,358 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package matrixexec
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/yamux"
+)
+
+func TestParseLocalForward(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    LocalForward
+		wantErr bool
+	}{
+		{
+			spec: "127.0.0.1:8080:localhost:80",
+			want: LocalForward{ListenAddr: "127.0.0.1", ListenPort: "8080", Target: "localhost:80"},
+		},
+		{spec: "127.0.0.1:8080", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLocalForward(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseLocalForward(%q) = %+v, want error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseLocalForward(%q) unexpected error: %v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLocalForward(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseRemoteForward(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    RemoteForward
+		wantErr bool
+	}{
+		{
+			spec: "0.0.0.0:2222:localhost:22",
+			want: RemoteForward{ListenAddr: "0.0.0.0", ListenPort: "2222", Target: "localhost:22"},
+		},
+		{spec: "0.0.0.0:2222", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRemoteForward(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseRemoteForward(%q) = %+v, want error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRemoteForward(%q) unexpected error: %v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRemoteForward(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+// newFakeStub serves the server side of session the same way
+// cmd/matrixexec-stub's serveStream does for the CONNECT and LISTEN
+// verbs this test exercises, except LISTEN hands its one simulated
+// incoming connection to onListen instead of opening a real socket.
+func newFakeStub(t *testing.T, session *yamux.Session, onListen func(addrPort string, stream net.Conn)) {
+	t.Helper()
+	go func() {
+		shell, err := session.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(io.Discard, shell)
+	}()
+	go func() {
+		for {
+			stream, err := session.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				r := bufio.NewReader(stream)
+				line, err := r.ReadString('\n')
+				if err != nil {
+					stream.Close()
+					return
+				}
+				verb, rest, _ := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+				switch verb {
+				case "CONNECT":
+					conn, err := net.Dial("tcp", rest)
+					if err != nil {
+						stream.Close()
+						return
+					}
+					relay(conn, stream, r)
+				case "LISTEN":
+					onListen(rest, stream)
+				}
+			}()
+		}
+	}()
+}
+
+// newEchoServer starts a TCP server that echoes back whatever it reads,
+// standing in for whatever -L/-R's target actually is.
+func newEchoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start echo server: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// freePort finds a currently-unused TCP port on 127.0.0.1, for -L tests
+// that need to know the listen address before ServeLocalForward picks
+// it.
+func freePort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port
+}
+
+func TestServeLocalForward(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	serverSession, err := yamux.Server(serverConn, nil)
+	if err != nil {
+		t.Fatalf("yamux.Server: %v", err)
+	}
+	newFakeStub(t, serverSession, func(addrPort string, stream net.Conn) {
+		stream.Close()
+	})
+
+	s, err := NewSession(clientConn)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer s.Close()
+
+	echoAddr := newEchoServer(t)
+	port := freePort(t)
+	fw := LocalForward{ListenAddr: "127.0.0.1", ListenPort: port, Target: echoAddr}
+	if err := s.ServeLocalForward(fw); err != nil {
+		t.Fatalf("ServeLocalForward: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		t.Fatalf("failed to dial forwarded port: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("got %q back through -L, want %q", buf, "hello")
+	}
+}
+
+func TestServeRemoteForward(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	serverSession, err := yamux.Server(serverConn, nil)
+	if err != nil {
+		t.Fatalf("yamux.Server: %v", err)
+	}
+
+	// remotePeer stands in for whatever external connection the stub's
+	// real serveListen would have accepted on the container side;
+	// stubSide is the end the fake stub relays against the mux stream,
+	// exactly like serveListen does with its accepted net.Conn.
+	remotePeer, stubSide := net.Pipe()
+	defer remotePeer.Close()
+
+	listenCh := make(chan string, 1)
+	newFakeStub(t, serverSession, func(addrPort string, ctrlStream net.Conn) {
+		listenCh <- addrPort
+		stream, err := serverSession.Open()
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(stream, "%s\n", addrPort); err != nil {
+			return
+		}
+		relay(stubSide, stream, nil)
+	})
+
+	s, err := NewSession(clientConn)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer s.Close()
+
+	echoAddr := newEchoServer(t)
+	fw := RemoteForward{ListenAddr: "0.0.0.0", ListenPort: "2222", Target: echoAddr}
+	if err := s.ServeRemoteForward(fw); err != nil {
+		t.Fatalf("ServeRemoteForward: %v", err)
+	}
+
+	select {
+	case got := <-listenCh:
+		if want := "0.0.0.0 2222"; got != want {
+			t.Errorf("stub received LISTEN %q, want %q", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the stub to see a LISTEN request")
+	}
+
+	// If ServeRemoteForward dialed fw.Target itself (the fix under
+	// test), writing to remotePeer bounces off the echo server and
+	// comes back here. If it instead re-entered the mux and asked the
+	// (fake) stub to CONNECT, nothing would ever echo it back, since
+	// this fake stub doesn't implement CONNECT-from-LISTEN.
+	remotePeer.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := remotePeer.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(remotePeer, buf); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("got %q back through -R, want %q", buf, "hello")
+	}
+}
+
+// TestServeRemoteForwardRouting exercises two concurrent -R forwards to
+// make sure each incoming stream is routed to its own target by the tag
+// serveListen writes, rather than to whichever -R's Accept loop happened
+// to win the race, as it did before the shared acceptRemoteForwards
+// dispatcher was introduced.
+func TestServeRemoteForwardRouting(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	serverSession, err := yamux.Server(serverConn, nil)
+	if err != nil {
+		t.Fatalf("yamux.Server: %v", err)
+	}
+
+	type conn struct {
+		addrPort string
+		peer     net.Conn
+	}
+	listenCh := make(chan conn, 2)
+	newFakeStub(t, serverSession, func(addrPort string, ctrlStream net.Conn) {
+		remotePeer, stubSide := net.Pipe()
+		listenCh <- conn{addrPort, remotePeer}
+		stream, err := serverSession.Open()
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(stream, "%s\n", addrPort); err != nil {
+			return
+		}
+		relay(stubSide, stream, nil)
+	})
+
+	s, err := NewSession(clientConn)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer s.Close()
+
+	echoA := newEchoServer(t)
+	echoB := newEchoServer(t)
+	fwA := RemoteForward{ListenAddr: "0.0.0.0", ListenPort: "2201", Target: echoA}
+	fwB := RemoteForward{ListenAddr: "0.0.0.0", ListenPort: "2202", Target: echoB}
+	if err := s.ServeRemoteForward(fwA); err != nil {
+		t.Fatalf("ServeRemoteForward(A): %v", err)
+	}
+	if err := s.ServeRemoteForward(fwB); err != nil {
+		t.Fatalf("ServeRemoteForward(B): %v", err)
+	}
+
+	peers := make(map[string]net.Conn)
+	for i := 0; i < 2; i++ {
+		select {
+		case c := <-listenCh:
+			peers[c.addrPort] = c.peer
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for both LISTEN requests")
+		}
+	}
+
+	for _, addrPort := range []string{"0.0.0.0 2201", "0.0.0.0 2202"} {
+		peer, ok := peers[addrPort]
+		if !ok {
+			t.Fatalf("no simulated connection recorded for %q", addrPort)
+		}
+		peer.SetDeadline(time.Now().Add(2 * time.Second))
+		payload := []byte("hello " + addrPort)
+		if _, err := peer.Write(payload); err != nil {
+			t.Fatalf("Write(%q): %v", addrPort, err)
+		}
+		buf := make([]byte, len(payload))
+		if _, err := io.ReadFull(peer, buf); err != nil {
+			t.Fatalf("ReadFull(%q): %v", addrPort, err)
+		}
+		if string(buf) != string(payload) {
+			t.Errorf("got %q back for %q, want %q (wrong forward's target)", buf, addrPort, payload)
+		}
+	}
+}
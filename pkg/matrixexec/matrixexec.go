@@ -0,0 +1,96 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package matrixexec multiplexes an interactive shell together with
+// on-demand TCP forwards and file copies over a single exec attach
+// connection, whichever container runtime it came from. The interactive
+// /bin/bash session always lives on channel 0 of the mux; forwards and
+// copies each get their own stream opened on demand. Bootstrap uploads
+// and runs the matrixexec-stub binary (cmd/matrixexec-stub) that speaks
+// the other side of the mux.
+package matrixexec
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/hashicorp/yamux"
+)
+
+// Session multiplexes an interactive shell (channel 0) together with
+// forwarding and copy streams over a single hijacked exec connection,
+// using yamux for the framing.
+type Session struct {
+	mux   *yamux.Session
+	shell net.Conn
+
+	// remoteForwards and remoteAcceptOnce back ServeRemoteForward: every
+	// active -R shares the one yamux Accept queue, so a single goroutine
+	// demultiplexes streams the stub opens for them by the tag each
+	// carries, rather than each -R racing the others for connections.
+	remoteForwardsMu sync.Mutex
+	remoteForwards   map[string]string
+	remoteAcceptOnce sync.Once
+}
+
+// NewSession wraps conn, the hijacked connection for the control exec
+// session running the matrixexec-stub binary, in a yamux client session
+// and opens channel 0 for the interactive shell. conn need not be a
+// net.Conn; any io.ReadWriteCloser from the selected runtime's Exec will
+// do.
+func NewSession(conn io.ReadWriteCloser) (*Session, error) {
+	session, err := yamux.Client(conn, nil)
+	if err != nil {
+		return nil, fmt.Errorf("matrixexec: failed to establish mux session: %v", err)
+	}
+	shell, err := session.Open()
+	if err != nil {
+		return nil, fmt.Errorf("matrixexec: failed to open shell channel: %v", err)
+	}
+	return &Session{mux: session, shell: shell}, nil
+}
+
+// Shell returns the channel-0 stream carrying the interactive /bin/bash
+// session.
+func (s *Session) Shell() net.Conn {
+	return s.shell
+}
+
+// Close tears down every channel along with the underlying mux session.
+func (s *Session) Close() error {
+	return s.mux.Close()
+}
+
+// relay copies bytes in both directions between local and stream until
+// either side closes, first draining buffered into local if the caller
+// already read past some header line on stream.
+func relay(local net.Conn, stream net.Conn, buffered io.Reader) {
+	defer local.Close()
+	defer stream.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		if buffered != nil {
+			io.Copy(local, buffered)
+		}
+		io.Copy(local, stream)
+		done <- struct{}{}
+	}()
+	go func() { io.Copy(stream, local); done <- struct{}{} }()
+	<-done
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package picker implements the interactive, fuzzy-searchable container
+// selector that replaced the original numbered stdin prompt.
+package picker
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/sahilm/fuzzy"
+
+	"github.com/lukegb/enterthematrix/pkg/runtime"
+)
+
+// model is the bubbletea model backing Pick.
+type model struct {
+	containers []runtime.Container
+	matches    []fuzzy.Match
+	input      textinput.Model
+	cursor     int
+	chosen     *runtime.Container
+}
+
+func newModel(containers []runtime.Container) model {
+	input := textinput.New()
+	input.Placeholder = "type to filter"
+	input.Focus()
+
+	m := model{containers: containers, input: input}
+	m.refreshMatches()
+	return m
+}
+
+func (m *model) refreshMatches() {
+	m.matches = fuzzy.FindFrom(m.input.Value(), containerSource(m.containers))
+	if m.cursor >= len(m.matches) {
+		m.cursor = len(m.matches) - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+}
+
+func (m model) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyEnter:
+			if len(m.matches) > 0 {
+				c := m.containers[m.matches[m.cursor].Index]
+				m.chosen = &c
+			}
+			return m, tea.Quit
+		case tea.KeyCtrlC, tea.KeyEsc:
+			return m, tea.Quit
+		case tea.KeyUp, tea.KeyCtrlP:
+			if m.cursor > 0 {
+				m.cursor--
+			}
+			return m, nil
+		case tea.KeyDown, tea.KeyCtrlN:
+			if m.cursor < len(m.matches)-1 {
+				m.cursor++
+			}
+			return m, nil
+		}
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	m.refreshMatches()
+	return m, cmd
+}
+
+func (m model) View() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Search: %s\n\n", m.input.View())
+	for i, match := range m.matches {
+		c := m.containers[match.Index]
+		cursor := "  "
+		if i == m.cursor {
+			cursor = "> "
+		}
+		fmt.Fprintf(&b, "%s%-30s %-24s up %s\n", cursor, c.Name, c.Image, time.Since(c.Created).Round(time.Second))
+	}
+	if len(m.matches) == 0 {
+		b.WriteString("  (no matches)\n")
+	}
+	return b.String()
+}
+
+// containerSource adapts []runtime.Container to fuzzy.Source, searching
+// across name, image, and labels in one pass.
+type containerSource []runtime.Container
+
+func (s containerSource) String(i int) string {
+	c := s[i]
+	parts := []string{c.Name, c.Image}
+	for k, v := range c.Labels {
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s containerSource) Len() int { return len(s) }
+
+// Pick runs the interactive fuzzy picker over containers and returns the
+// chosen one. ok is false if the user aborted without choosing.
+func Pick(containers []runtime.Container) (c runtime.Container, ok bool, err error) {
+	p := tea.NewProgram(newModel(containers))
+	result, err := p.Run()
+	if err != nil {
+		return runtime.Container{}, false, fmt.Errorf("picker: %v", err)
+	}
+
+	final := result.(model)
+	if final.chosen == nil {
+		return runtime.Container{}, false, nil
+	}
+	return *final.chosen, true, nil
+}
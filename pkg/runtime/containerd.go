@@ -0,0 +1,179 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/namespaces"
+)
+
+// containerdNamespace is the namespace enterthematrix looks for
+// containers in. containerd has no single "the running containers"
+// concept the way dockerd does; "default" is what ctr and most
+// nerdctl setups use.
+const containerdNamespace = "default"
+
+// containerdRuntime talks directly to a containerd daemon over its
+// native gRPC API, operating on tasks rather than a Docker-style
+// container list.
+type containerdRuntime struct {
+	client *containerd.Client
+}
+
+// NewContainerd connects to the containerd socket at addr (e.g.
+// /run/containerd/containerd.sock, taken from $CONTAINERD_ADDRESS).
+func NewContainerd(addr string) (Runtime, error) {
+	client, err := containerd.New(addr)
+	if err != nil {
+		return nil, fmt.Errorf("runtime: failed to connect to containerd at %s: %v", addr, err)
+	}
+	return &containerdRuntime{client: client}, nil
+}
+
+func (c *containerdRuntime) ctx(ctx context.Context) context.Context {
+	return namespaces.WithNamespace(ctx, containerdNamespace)
+}
+
+func (c *containerdRuntime) ListContainers(ctx context.Context) ([]Container, error) {
+	ctrs, err := c.client.Containers(c.ctx(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("runtime: failed to list containerd containers: %v", err)
+	}
+
+	var out []Container
+	for _, ctr := range ctrs {
+		task, err := ctr.Task(c.ctx(ctx), nil)
+		if err != nil {
+			// No running task for this container - skip it, same as
+			// dockerd only listing running containers by default.
+			continue
+		}
+		status, err := task.Status(c.ctx(ctx))
+		if err != nil || status.Status != containerd.Running {
+			continue
+		}
+		info, err := ctr.Info(c.ctx(ctx))
+		if err != nil {
+			continue
+		}
+		out = append(out, Container{
+			ID:      ctr.ID(),
+			Name:    ctr.ID(),
+			Image:   info.Image,
+			Created: info.CreatedAt,
+			Labels:  info.Labels,
+		})
+	}
+	return out, nil
+}
+
+func (c *containerdRuntime) Name() string { return "containerd" }
+
+func (c *containerdRuntime) Exec(ctx context.Context, containerID string, cfg ExecConfig) (ExecSession, error) {
+	nsCtx := c.ctx(ctx)
+
+	ctr, err := c.client.LoadContainer(nsCtx, containerID)
+	if err != nil {
+		return nil, fmt.Errorf("runtime: failed to load container %s: %v", containerID, err)
+	}
+	task, err := ctr.Task(nsCtx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("runtime: failed to load task for %s: %v", containerID, err)
+	}
+
+	spec, err := ctr.Spec(nsCtx)
+	if err != nil {
+		return nil, fmt.Errorf("runtime: failed to read container spec for %s: %v", containerID, err)
+	}
+	processSpec := spec.Process
+	processSpec.Args = cfg.Cmd
+	processSpec.Terminal = cfg.Tty
+
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+
+	execID := fmt.Sprintf("enterthematrix-%s", containerID[:12])
+	process, err := task.Exec(nsCtx, execID, processSpec, cio.NewCreator(cio.WithStreams(stdinR, stdoutW, stdoutW)))
+	if err != nil {
+		return nil, fmt.Errorf("runtime: failed to create containerd exec process: %v", err)
+	}
+	waitCh, err := process.Wait(nsCtx)
+	if err != nil {
+		return nil, fmt.Errorf("runtime: failed to wait on containerd exec process: %v", err)
+	}
+	if err := process.Start(nsCtx); err != nil {
+		return nil, fmt.Errorf("runtime: failed to start containerd exec process: %v", err)
+	}
+
+	session := &containerdExecSession{nsCtx: nsCtx, process: process, stdin: stdinW, stdout: stdoutR, done: make(chan struct{})}
+
+	// containerd closes the container-side FIFO once the process exits
+	// but never closes the io.Pipe writer we handed it, so stdoutR.Read
+	// would otherwise block forever instead of returning io.EOF. Close
+	// both pipe ends ourselves once the exit status is in, and stash it
+	// for ExitCode, since waitCh only ever delivers one value.
+	go func() {
+		status := <-waitCh
+		stdoutW.Close()
+		stdinW.Close()
+		session.status = status
+		close(session.done)
+	}()
+
+	return session, nil
+}
+
+// containerdExecSession adapts a containerd exec *containerd.Process,
+// whose I/O is wired through cio streams, into the ExecSession
+// interface.
+type containerdExecSession struct {
+	nsCtx   context.Context
+	process containerd.Process
+
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	done   chan struct{}
+	status containerd.ExitStatus
+}
+
+func (s *containerdExecSession) Read(p []byte) (int, error) { return s.stdout.Read(p) }
+
+func (s *containerdExecSession) Write(p []byte) (int, error) { return s.stdin.Write(p) }
+
+func (s *containerdExecSession) Close() error {
+	s.stdin.Close()
+	s.stdout.Close()
+	return s.process.IO().Close()
+}
+
+func (s *containerdExecSession) Resize(ctx context.Context, size ResizeConfig) error {
+	return s.process.Resize(s.nsCtx, uint32(size.Width), uint32(size.Height))
+}
+
+func (s *containerdExecSession) ExitCode(ctx context.Context) (int, error) {
+	select {
+	case <-s.done:
+		return int(s.status.ExitCode()), s.status.Error()
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
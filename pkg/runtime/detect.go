@@ -0,0 +1,64 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runtime
+
+import (
+	"fmt"
+	"os"
+)
+
+// Detect picks a Runtime to use. explicit, when non-empty (the
+// --runtime flag), wins outright; otherwise we look for the
+// runtime-specific environment variables each ecosystem's tooling
+// already sets, falling back to plain Docker since that's what this
+// tool has always assumed.
+func Detect(explicit string) (Runtime, error) {
+	switch explicit {
+	case "docker":
+		return NewDocker()
+	case "podman":
+		return NewPodman(podmanAddr())
+	case "containerd":
+		return NewContainerd(containerdAddr())
+	case "":
+		// Fall through to auto-detection below.
+	default:
+		return nil, fmt.Errorf("runtime: unknown --runtime %q (want docker, podman, or containerd)", explicit)
+	}
+
+	if addr := os.Getenv("CONTAINERD_ADDRESS"); addr != "" {
+		return NewContainerd(addr)
+	}
+	if addr := os.Getenv("CONTAINER_HOST"); addr != "" {
+		return NewPodman(addr)
+	}
+	return NewDocker()
+}
+
+func podmanAddr() string {
+	if addr := os.Getenv("CONTAINER_HOST"); addr != "" {
+		return addr
+	}
+	return "unix:///run/podman/podman.sock"
+}
+
+func containerdAddr() string {
+	if addr := os.Getenv("CONTAINERD_ADDRESS"); addr != "" {
+		return addr
+	}
+	return "/run/containerd/containerd.sock"
+}
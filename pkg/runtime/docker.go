@@ -0,0 +1,138 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// execPollInterval is how often ExitCode polls ContainerExecInspect
+// while waiting for the process to finish; the Docker API has no way
+// to block on exec completion.
+const execPollInterval = 200 * time.Millisecond
+
+// dockerRuntime talks to a dockerd over the API client picks up from
+// DOCKER_HOST et al.
+type dockerRuntime struct {
+	cli *client.Client
+}
+
+// NewDocker connects to the Docker daemon using the standard
+// DOCKER_HOST/DOCKER_TLS_VERIFY/DOCKER_CERT_PATH environment variables.
+func NewDocker() (Runtime, error) {
+	cli, err := client.NewEnvClient()
+	if err != nil {
+		return nil, fmt.Errorf("runtime: failed to connect to Docker: %v", err)
+	}
+	return &dockerRuntime{cli: cli}, nil
+}
+
+func (d *dockerRuntime) Name() string { return "docker" }
+
+func (d *dockerRuntime) ListContainers(ctx context.Context) ([]Container, error) {
+	cs, err := d.cli.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Container
+	for _, c := range cs {
+		if len(c.Names) != 1 {
+			// Get out of here with your multiple names
+			continue
+		}
+		out = append(out, Container{
+			ID:      c.ID,
+			Name:    c.Names[0],
+			Image:   c.Image,
+			Created: time.Unix(c.Created, 0),
+			Labels:  c.Labels,
+		})
+	}
+	return out, nil
+}
+
+func (d *dockerRuntime) Exec(ctx context.Context, containerID string, cfg ExecConfig) (ExecSession, error) {
+	createResp, err := d.cli.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		Tty:          cfg.Tty,
+		AttachStdin:  cfg.AttachStdin,
+		AttachStdout: cfg.AttachStdout,
+		AttachStderr: cfg.AttachStderr,
+		Cmd:          cfg.Cmd,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("runtime: failed to create exec environment: %v", err)
+	}
+
+	hijackResp, err := d.cli.ContainerExecAttach(ctx, createResp.ID, types.ExecStartCheck{
+		Tty: cfg.Tty,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("runtime: failed to attach to exec environment: %v", err)
+	}
+
+	return &dockerExecSession{cli: d.cli, execID: createResp.ID, hijack: hijackResp}, nil
+}
+
+// dockerExecSession adapts a docker HijackedResponse plus its exec ID
+// into the runtime.ExecSession interface.
+type dockerExecSession struct {
+	cli    *client.Client
+	execID string
+	hijack types.HijackedResponse
+}
+
+func (s *dockerExecSession) Read(p []byte) (int, error) { return s.hijack.Reader.Read(p) }
+
+func (s *dockerExecSession) Write(p []byte) (int, error) { return s.hijack.Conn.Write(p) }
+
+func (s *dockerExecSession) Close() error {
+	s.hijack.Close()
+	return nil
+}
+
+func (s *dockerExecSession) Resize(ctx context.Context, size ResizeConfig) error {
+	return s.cli.ContainerExecResize(ctx, s.execID, types.ResizeOptions{
+		Height: size.Height,
+		Width:  size.Width,
+	})
+}
+
+// ExitCode polls ContainerExecInspect until the process has exited,
+// since the API gives us no way to wait for it directly.
+func (s *dockerExecSession) ExitCode(ctx context.Context) (int, error) {
+	for {
+		inspect, err := s.cli.ContainerExecInspect(ctx, s.execID)
+		if err != nil {
+			return 0, fmt.Errorf("runtime: failed to inspect exec session: %v", err)
+		}
+		if !inspect.Running {
+			return inspect.ExitCode, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(execPollInterval):
+		}
+	}
+}
@@ -0,0 +1,290 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runtime
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// podmanExecPollInterval is how often ExitCode polls the exec inspect
+// endpoint while waiting for the process to finish, mirroring
+// dockerRuntime's execPollInterval.
+const podmanExecPollInterval = 200 * time.Millisecond
+
+// podmanAPIPrefix is the libpod API's path prefix; it, not the
+// Docker-compat prefix, is what exposes exec inspect's Running field.
+const podmanAPIPrefix = "/v4.0.0/libpod"
+
+// podmanRuntime talks to a Podman REST socket directly over net/http
+// rather than through github.com/containers/podman/v4's bindings
+// package: that package pulls in containers/storage's cgo
+// graph-driver backends (btrfs, devicemapper) and gpgme, none of which
+// a Docker- or containerd-only user has the headers for, and `Runtime`
+// only needs a handful of HTTP calls in the first place.
+type podmanRuntime struct {
+	dial func(ctx context.Context) (net.Conn, error)
+	http *http.Client
+}
+
+// NewPodman connects to the Podman API at uri (e.g.
+// unix:///run/podman/podman.sock, taken from $CONTAINER_HOST).
+func NewPodman(uri string) (Runtime, error) {
+	dial, err := podmanDialer(uri)
+	if err != nil {
+		return nil, err
+	}
+	return &podmanRuntime{
+		dial: dial,
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dial(ctx)
+				},
+			},
+		},
+	}, nil
+}
+
+// podmanDialer turns uri into a dial func for the configured transport:
+// a Unix socket path, or a plain TCP address.
+func podmanDialer(uri string) (func(ctx context.Context) (net.Conn, error), error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("runtime: invalid Podman address %q: %v", uri, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		path := u.Path
+		if path == "" {
+			path = u.Opaque
+		}
+		return func(ctx context.Context) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}, nil
+	case "tcp", "http":
+		addr := u.Host
+		return func(ctx context.Context) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", addr)
+		}, nil
+	default:
+		return nil, fmt.Errorf("runtime: unsupported Podman address scheme %q (want unix:// or tcp://)", u.Scheme)
+	}
+}
+
+func (p *podmanRuntime) url(path string) string {
+	return "http://d" + podmanAPIPrefix + path
+}
+
+func (p *podmanRuntime) Name() string { return "podman" }
+
+func (p *podmanRuntime) ListContainers(ctx context.Context) ([]Container, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url("/containers/json"), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("runtime: failed to list Podman containers: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("runtime: failed to list Podman containers: %s", resp.Status)
+	}
+
+	var cs []struct {
+		ID      string            `json:"Id"`
+		Names   []string          `json:"Names"`
+		Image   string            `json:"Image"`
+		Created int64             `json:"Created"`
+		Labels  map[string]string `json:"Labels"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&cs); err != nil {
+		return nil, fmt.Errorf("runtime: failed to decode Podman container list: %v", err)
+	}
+
+	var out []Container
+	for _, c := range cs {
+		if len(c.Names) != 1 {
+			continue
+		}
+		out = append(out, Container{
+			ID:      c.ID,
+			Name:    c.Names[0],
+			Image:   c.Image,
+			Created: time.Unix(c.Created, 0),
+			Labels:  c.Labels,
+		})
+	}
+	return out, nil
+}
+
+// Exec creates a Podman exec session and attaches to it. Attaching is a
+// raw HTTP connection upgrade rather than a regular request/response, so
+// it's done on its own dialed connection, with the request written by
+// hand the same way net/http's client writes one internally.
+func (p *podmanRuntime) Exec(ctx context.Context, containerID string, cfg ExecConfig) (ExecSession, error) {
+	createBody, err := json.Marshal(map[string]interface{}{
+		"AttachStdin":  cfg.AttachStdin,
+		"AttachStdout": cfg.AttachStdout,
+		"AttachStderr": cfg.AttachStderr,
+		"Tty":          cfg.Tty,
+		"Cmd":          cfg.Cmd,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url("/containers/"+containerID+"/exec"), bytes.NewReader(createBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("runtime: failed to create Podman exec session: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("runtime: failed to create Podman exec session: %s", resp.Status)
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return nil, fmt.Errorf("runtime: failed to decode Podman exec session: %v", err)
+	}
+
+	startBody, err := json.Marshal(map[string]interface{}{"Detach": false, "Tty": cfg.Tty})
+	if err != nil {
+		return nil, err
+	}
+	conn, err := p.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("runtime: failed to dial Podman for exec start: %v", err)
+	}
+	br, err := podmanHijack(conn, p.url("/exec/"+created.ID+"/start"), startBody)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("runtime: failed to attach to Podman exec session: %v", err)
+	}
+
+	return &podmanExecSession{rt: p, execID: created.ID, conn: conn, r: br}, nil
+}
+
+// podmanHijack writes a POST to the given URL over conn and parses the
+// response headers, leaving conn free to be read and written directly
+// afterwards - Podman, like Docker, upgrades the connection to a raw
+// duplex stream for exec start rather than returning a normal body.
+func podmanHijack(conn net.Conn, rawURL string, body []byte) (*bufio.Reader, error) {
+	req, err := http.NewRequest(http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("exec start returned %s", resp.Status)
+	}
+	return br, nil
+}
+
+// podmanExecSession adapts a hijacked Podman exec-start connection into
+// the incremental ExecSession interface.
+type podmanExecSession struct {
+	rt     *podmanRuntime
+	execID string
+	conn   net.Conn
+	r      *bufio.Reader
+}
+
+func (s *podmanExecSession) Read(p []byte) (int, error) { return s.r.Read(p) }
+
+func (s *podmanExecSession) Write(p []byte) (int, error) { return s.conn.Write(p) }
+
+func (s *podmanExecSession) Close() error { return s.conn.Close() }
+
+func (s *podmanExecSession) Resize(ctx context.Context, size ResizeConfig) error {
+	path := fmt.Sprintf("/exec/%s/resize?h=%d&w=%d", s.execID, size.Height, size.Width)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.rt.url(path), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.rt.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("runtime: failed to resize Podman exec session: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("runtime: failed to resize Podman exec session: %s", resp.Status)
+	}
+	return nil
+}
+
+// ExitCode polls the exec inspect endpoint until the process has
+// exited, since the API gives us no way to wait for it directly.
+func (s *podmanExecSession) ExitCode(ctx context.Context) (int, error) {
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.rt.url("/exec/"+s.execID+"/json"), nil)
+		if err != nil {
+			return 0, err
+		}
+		resp, err := s.rt.http.Do(req)
+		if err != nil {
+			return 0, fmt.Errorf("runtime: failed to inspect Podman exec session: %v", err)
+		}
+		var inspect struct {
+			ExitCode int
+			Running  bool
+		}
+		err = json.NewDecoder(resp.Body).Decode(&inspect)
+		resp.Body.Close()
+		if err != nil {
+			return 0, fmt.Errorf("runtime: failed to decode Podman exec session: %v", err)
+		}
+		if !inspect.Running {
+			return inspect.ExitCode, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(podmanExecPollInterval):
+		}
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Reconnecting wraps an ExecSession so that a dropped connection - a
+// Read or Write that returns an error other than io.EOF - triggers
+// automatic reconnection: a fresh exec is created against the same
+// runtime/container/config, with exponential backoff between attempts.
+// This is meant for long-lived interactive sessions over flaky
+// networks, not the mux sessions, which manage their own streams.
+type Reconnecting struct {
+	rt          Runtime
+	containerID string
+	cfg         ExecConfig
+	ctx         context.Context
+
+	mu         sync.Mutex
+	current    ExecSession
+	maxRetries int
+	baseDelay  time.Duration
+}
+
+// NewReconnecting creates the initial exec session and wraps it for
+// automatic reconnection.
+func NewReconnecting(ctx context.Context, rt Runtime, containerID string, cfg ExecConfig) (*Reconnecting, error) {
+	session, err := rt.Exec(ctx, containerID, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Reconnecting{
+		rt:          rt,
+		containerID: containerID,
+		cfg:         cfg,
+		ctx:         ctx,
+		current:     session,
+		maxRetries:  5,
+		baseDelay:   500 * time.Millisecond,
+	}, nil
+}
+
+// getCurrent returns the live session under the lock, so Read and
+// Write never race a swap made by reconnect.
+func (r *Reconnecting) getCurrent() ExecSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+func (r *Reconnecting) reconnect() error {
+	delay := r.baseDelay
+	var lastErr error
+	for attempt := 0; attempt < r.maxRetries; attempt++ {
+		session, err := r.rt.Exec(r.ctx, r.containerID, r.cfg)
+		if err == nil {
+			r.mu.Lock()
+			old := r.current
+			r.current = session
+			r.mu.Unlock()
+			old.Close()
+			return nil
+		}
+		lastErr = err
+		time.Sleep(delay)
+		delay *= 2
+	}
+	return fmt.Errorf("runtime: gave up reconnecting after %d attempts: %v", r.maxRetries, lastErr)
+}
+
+func (r *Reconnecting) Read(p []byte) (int, error) {
+	n, err := r.getCurrent().Read(p)
+	if err != nil && err != io.EOF {
+		if rerr := r.reconnect(); rerr == nil {
+			return r.getCurrent().Read(p)
+		}
+	}
+	return n, err
+}
+
+func (r *Reconnecting) Write(p []byte) (int, error) {
+	n, err := r.getCurrent().Write(p)
+	if err != nil {
+		if rerr := r.reconnect(); rerr == nil {
+			return r.getCurrent().Write(p)
+		}
+	}
+	return n, err
+}
+
+func (r *Reconnecting) Close() error {
+	return r.getCurrent().Close()
+}
+
+func (r *Reconnecting) Resize(ctx context.Context, size ResizeConfig) error {
+	return r.getCurrent().Resize(ctx, size)
+}
+
+func (r *Reconnecting) ExitCode(ctx context.Context) (int, error) {
+	return r.getCurrent().ExitCode(ctx)
+}
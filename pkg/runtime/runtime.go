@@ -0,0 +1,86 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package runtime abstracts the container-runtime operations
+// enterthematrix needs - listing running containers and exec'ing into
+// one - behind a single interface, so the rest of the tool doesn't care
+// whether it's talking to dockerd, Podman, or containerd.
+package runtime
+
+import (
+	"context"
+	"time"
+)
+
+// Container is the information enterthematrix needs about a running
+// container, independent of which runtime reported it.
+type Container struct {
+	// ID is the runtime-native container (or task) ID.
+	ID string
+	// Name is the single name dockerd-style runtimes report; runtimes
+	// that don't have this concept should synthesize one.
+	Name string
+	// Image is the image the container was created from, as the
+	// runtime names it.
+	Image string
+	// Created is when the container started, used to show uptime.
+	Created time.Time
+	// Labels are the container's runtime labels, if any.
+	Labels map[string]string
+}
+
+// ExecConfig describes the command to run inside a container and which
+// streams to attach, mirroring docker/docker/api/types.ExecConfig since
+// that's the runtime most of this tool's users will have.
+type ExecConfig struct {
+	Cmd          []string
+	Tty          bool
+	AttachStdin  bool
+	AttachStdout bool
+	AttachStderr bool
+}
+
+// ResizeConfig is a terminal size, in character cells.
+type ResizeConfig struct {
+	Width  uint
+	Height uint
+}
+
+// ExecSession is a live attached exec session: its Read/Write carry the
+// (possibly demuxed, by the caller) stdout/stdin, and Resize adjusts the
+// remote TTY size when the session was created with Tty: true.
+type ExecSession interface {
+	Read(p []byte) (int, error)
+	Write(p []byte) (int, error)
+	Close() error
+	Resize(ctx context.Context, size ResizeConfig) error
+	// ExitCode blocks until the exec'd process has finished and
+	// returns its exit status.
+	ExitCode(ctx context.Context) (int, error)
+}
+
+// Runtime is a container runtime enterthematrix can list containers in
+// and exec commands against.
+type Runtime interface {
+	// Name identifies the runtime for logging and for --runtime.
+	Name() string
+	// ListContainers returns every running container the runtime knows
+	// about. Callers are expected to apply their own name/label
+	// filtering on top of this.
+	ListContainers(ctx context.Context) ([]Container, error)
+	// Exec creates and attaches to a new exec session in containerID.
+	Exec(ctx context.Context, containerID string, cfg ExecConfig) (ExecSession, error)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lukegb/enterthematrix/pkg/asciicast"
+)
+
+var (
+	recordPath = flag.String("record", "", "record the session to an asciicast v2 file")
+	streamURL  = flag.String("stream", "", "push the same recording frames to a ws:// endpoint for live viewing")
+)
+
+// recording bundles whatever sinks --record/--stream asked for, plus the
+// handles that need closing when the session ends.
+type recording struct {
+	sink    asciicast.EventSink
+	closers []func() error
+}
+
+// wantsRecording reports whether --record or --stream were passed.
+func wantsRecording() bool {
+	return *recordPath != "" || *streamURL != ""
+}
+
+// startRecording opens every sink --record/--stream asked for and
+// returns them combined into one, along with a Close that tears all of
+// them down.
+func startRecording(width, height int) (*recording, error) {
+	env := map[string]string{"SHELL": os.Getenv("SHELL"), "TERM": os.Getenv("TERM")}
+
+	var sinks asciicast.MultiSink
+	var closers []func() error
+
+	if *recordPath != "" {
+		f, err := os.Create(*recordPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create --record file %s: %v", *recordPath, err)
+		}
+		w, err := asciicast.NewWriter(f, width, height, env)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to write asciicast header to %s: %v", *recordPath, err)
+		}
+		sinks = append(sinks, w)
+		closers = append(closers, f.Close)
+	}
+
+	if *streamURL != "" {
+		ws, err := asciicast.DialWS(*streamURL, width, height, env)
+		if err != nil {
+			for _, c := range closers {
+				c()
+			}
+			return nil, err
+		}
+		sinks = append(sinks, ws)
+		closers = append(closers, ws.Close)
+	}
+
+	return &recording{sink: sinks, closers: closers}, nil
+}
+
+func (r *recording) Close() {
+	for _, c := range r.closers {
+		c()
+	}
+}
+
+// recordResize emits a resize frame, used whenever SIGWINCH fires.
+func (r *recording) recordResize(width, height uint) {
+	if r == nil {
+		return
+	}
+	r.sink.WriteEvent(time.Now(), asciicast.Resize, fmt.Sprintf("%dx%d", width, height))
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2017 Luke Granger-Brown
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+
+	"github.com/lukegb/enterthematrix/pkg/filter"
+	"github.com/lukegb/enterthematrix/pkg/runtime"
+)
+
+var (
+	validName = regexp.MustCompile(`^.*_[a-f0-9]{8}$`)
+
+	nameFlag    = flag.String("name", "", "select the container with exactly this name, skipping the picker")
+	namePattern = flag.String("name-pattern", "", "override the default "+validName.String()+" name filter with this regexp")
+
+	filterFlags stringSliceFlag
+)
+
+func init() {
+	flag.Var(&filterFlags, "filter", `narrow the container list: label=key=value or name=~regex (repeatable)`)
+}
+
+// candidateContainers applies --name, --name-pattern/the default name
+// filter, and any --filter predicates to containers, returning the
+// containers a user could plausibly mean.
+func candidateContainers(containers []runtime.Container) ([]runtime.Container, error) {
+	if *nameFlag != "" {
+		for _, c := range containers {
+			if c.Name == *nameFlag {
+				return []runtime.Container{c}, nil
+			}
+		}
+		return nil, fmt.Errorf("no running container named %q", *nameFlag)
+	}
+
+	nameRE := validName
+	if *namePattern != "" {
+		re, err := regexp.Compile(*namePattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --name-pattern %q: %v", *namePattern, err)
+		}
+		nameRE = re
+	}
+
+	var filters []filter.Filter
+	for _, spec := range filterFlags {
+		f, err := filter.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+
+	var out []runtime.Container
+	for _, c := range containers {
+		if !nameRE.MatchString(c.Name) {
+			continue
+		}
+		out = append(out, c)
+	}
+	return filter.Apply(out, filters), nil
+}